@@ -0,0 +1,127 @@
+package ipamshared
+
+import (
+	"net"
+	"time"
+
+	"github.com/Nexinto/go-ipam"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	assignTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ipam_assign_total",
+		Help: "Number of address/range assignments, by provider, tag and result.",
+	}, []string{"provider", "tag", "result"})
+
+	assignDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "ipam_assign_duration_seconds",
+		Help: "Time taken by assignment calls to the IPAM backend, by provider and tag.",
+	}, []string{"provider", "tag"})
+
+	// unassignTotal is only labelled by provider, not tag: Ipam.Unassign
+	// takes the address being freed, not the tag it was assigned under,
+	// and the interface has no reverse lookup from address back to tag.
+	unassignTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ipam_unassign_total",
+		Help: "Number of address/range unassignments, by provider and result.",
+	}, []string{"provider", "result"})
+
+	// poolUtilization is only populated for range/prefix allocations
+	// (AssignRange/AssignPrefix), where the allocated CIDR tells us the
+	// pool size. A plain Assign has no bounded pool to measure against,
+	// so it never touches this gauge.
+	poolUtilization = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ipam_pool_utilization",
+		Help: "Fraction of a range/CIDR pool's addresses currently assigned, by provider and tag.",
+	}, []string{"provider", "tag"})
+
+	reconcileTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ipam_reconcile_total",
+		Help: "Number of IpAddress reconciles, by provider and result.",
+	}, []string{"provider", "result"})
+)
+
+// poolSize returns the number of addresses covered by cidr.
+func poolSize(cidr string) (float64, bool) {
+	_, ipnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return 0, false
+	}
+
+	ones, bits := ipnet.Mask.Size()
+	size := 1
+	for i := 0; i < bits-ones; i++ {
+		size *= 2
+	}
+
+	return float64(size), true
+}
+
+func resultLabel(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "success"
+}
+
+// metricsIpam decorates an ipam.Ipam backend with Prometheus counters,
+// histograms and gauges, labelled by provider and (where the call gives us
+// one) tag. It embeds the backend so any ipam.Ipam method we don't
+// explicitly instrument still passes through.
+type metricsIpam struct {
+	provider string
+	ipam.Ipam
+}
+
+// WithMetrics wraps backend so that Assign, AssignRange, AssignPrefix and
+// Unassign calls are observed under provider's label, without the backend
+// implementation needing to know about Prometheus at all. Search and Lookup
+// pass through to the backend without being measured.
+func WithMetrics(provider string, backend ipam.Ipam) ipam.Ipam {
+	return &metricsIpam{provider: provider, Ipam: backend}
+}
+
+func (m *metricsIpam) Assign(tag string) (string, error) {
+	start := time.Now()
+	ip, err := m.Ipam.Assign(tag)
+	assignDuration.WithLabelValues(m.provider, tag).Observe(time.Since(start).Seconds())
+	assignTotal.WithLabelValues(m.provider, tag, resultLabel(err)).Inc()
+	return ip, err
+}
+
+func (m *metricsIpam) AssignRange(tag string, count int) (string, []string, error) {
+	start := time.Now()
+	cidr, hosts, err := m.Ipam.AssignRange(tag, count)
+	assignDuration.WithLabelValues(m.provider, tag).Observe(time.Since(start).Seconds())
+	assignTotal.WithLabelValues(m.provider, tag, resultLabel(err)).Inc()
+	m.observePoolUtilization(tag, cidr, hosts, err)
+	return cidr, hosts, err
+}
+
+func (m *metricsIpam) AssignPrefix(tag string, prefixLength int) (string, []string, error) {
+	start := time.Now()
+	cidr, hosts, err := m.Ipam.AssignPrefix(tag, prefixLength)
+	assignDuration.WithLabelValues(m.provider, tag).Observe(time.Since(start).Seconds())
+	assignTotal.WithLabelValues(m.provider, tag, resultLabel(err)).Inc()
+	m.observePoolUtilization(tag, cidr, hosts, err)
+	return cidr, hosts, err
+}
+
+func (m *metricsIpam) observePoolUtilization(tag, cidr string, hosts []string, err error) {
+	if err != nil {
+		return
+	}
+
+	if size, ok := poolSize(cidr); ok && size > 0 {
+		poolUtilization.WithLabelValues(m.provider, tag).Set(float64(len(hosts)) / size)
+	}
+}
+
+func (m *metricsIpam) Unassign(ip string) error {
+	err := m.Ipam.Unassign(ip)
+	unassignTotal.WithLabelValues(m.provider, resultLabel(err)).Inc()
+	return err
+}
@@ -0,0 +1,51 @@
+package ipamshared
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestWithMetricsDelegatesAssign(t *testing.T) {
+	backend := &fakeIpam{assignIP: "10.0.0.1"}
+	wrapped := WithMetrics("test-assign", backend)
+
+	ip, err := wrapped.Assign("web")
+	if err != nil {
+		t.Fatalf("Assign() returned error: %s", err)
+	}
+	if ip != "10.0.0.1" {
+		t.Errorf("expected Assign() to return the backend's address, got %q", ip)
+	}
+}
+
+func TestWithMetricsDelegatesUnassignError(t *testing.T) {
+	backend := &fakeIpam{unassignErr: fmt.Errorf("boom")}
+	wrapped := WithMetrics("test-unassign", backend)
+
+	if err := wrapped.Unassign("10.0.0.1"); err == nil {
+		t.Error("expected Unassign() to propagate the backend error")
+	}
+}
+
+func TestWithMetricsObservesPoolUtilization(t *testing.T) {
+	backend := &fakeIpam{rangeCidr: "10.0.0.0/30", rangeHosts: []string{"10.0.0.1", "10.0.0.2"}}
+	wrapped := WithMetrics("test-pool", backend)
+
+	cidr, hosts, err := wrapped.AssignPrefix("subnet", 30)
+	if err != nil {
+		t.Fatalf("AssignPrefix() returned error: %s", err)
+	}
+	if cidr != "10.0.0.0/30" || len(hosts) != 2 {
+		t.Fatalf("expected the backend's cidr/hosts to pass through, got %q %v", cidr, hosts)
+	}
+
+	metric, err := poolUtilization.GetMetricWithLabelValues("test-pool", "subnet")
+	if err != nil {
+		t.Fatalf("could not read ipam_pool_utilization: %s", err)
+	}
+	if got := testutil.ToFloat64(metric); got != 0.5 {
+		t.Errorf("expected pool utilization 0.5 (2 of 4 addresses), got %v", got)
+	}
+}
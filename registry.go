@@ -0,0 +1,49 @@
+package ipamshared
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/Nexinto/go-ipam"
+)
+
+// BackendFactory builds an ipam.Ipam instance from a provider-specific
+// configuration map (e.g. parsed from environment variables or a
+// ConfigMap). It is supplied by each provider package when it registers
+// itself.
+type BackendFactory func(config map[string]string) (ipam.Ipam, error)
+
+var (
+	backendsMu sync.RWMutex
+	backends   = map[string]BackendFactory{}
+)
+
+// RegisterBackend makes an IPAM backend available under name. Providers
+// call this from an init() function, mirroring how database/sql drivers
+// register themselves.
+func RegisterBackend(name string, factory BackendFactory) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+
+	if _, exists := backends[name]; exists {
+		panic(fmt.Sprintf("ipamshared: backend '%s' already registered", name))
+	}
+
+	backends[name] = factory
+}
+
+// NewBackend builds the named backend with the given configuration. It
+// returns an error if no backend was registered under that name, so this
+// is normally used with Status.Provider or another operator-supplied
+// selector.
+func NewBackend(name string, config map[string]string) (ipam.Ipam, error) {
+	backendsMu.RLock()
+	factory, ok := backends[name]
+	backendsMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown ipam backend '%s'", name)
+	}
+
+	return factory(config)
+}
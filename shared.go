@@ -3,19 +3,81 @@ package ipamshared
 import (
 	"bytes"
 	"fmt"
+	"sort"
+	"strings"
 	"text/template"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
 	"github.com/Nexinto/go-ipam"
 
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
 
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	ipamv1 "github.com/Nexinto/k8s-ipam/pkg/apis/ipam.nexinto.com/v1"
 	ipamclientset "github.com/Nexinto/k8s-ipam/pkg/client/clientset/versioned"
+	ipamscheme "github.com/Nexinto/k8s-ipam/pkg/client/clientset/versioned/scheme"
+	ipaminformers "github.com/Nexinto/k8s-ipam/pkg/client/informers/externalversions/ipam.nexinto.com/v1"
+	ipamlisters "github.com/Nexinto/k8s-ipam/pkg/client/listers/ipam.nexinto.com/v1"
+)
+
+// Event reasons emitted via Recorder, filterable with e.g.
+// `kubectl get events --field-selector reason=AssignFailed`.
+const (
+	ReasonAssigned       = "Assigned"
+	ReasonAssignFailed   = "AssignFailed"
+	ReasonUnassigned     = "Unassigned"
+	ReasonUnassignFailed = "UnassignFailed"
+	ReasonRefNotFound    = "RefNotFound"
+	ReasonRefAmbiguous   = "RefAmbiguous"
+	ReasonDrifted        = "Drifted"
+)
+
+const controllerAgentName = "ipam-controller"
+
+// Finalizer is installed on an IpAddress as soon as it has been assigned
+// an address or range. For an object that owns its allocation (no
+// Spec.Ref) it is only removed once Ipam.Unassign has succeeded; an object
+// that is a reference, or was never assigned, has it removed without any
+// Unassign call. This guarantees we never lose track of an allocation
+// because the object was deleted before we could free it.
+const Finalizer = "ipam.nexinto.com/allocated"
+
+// DeletionMode controls how SharedController handles an IpAddress that is
+// being deleted while other IpAddress objects still refer to it.
+type DeletionMode int
+
+const (
+	// BlockOnDependents refuses to unassign the address while dependents
+	// exist and keeps retrying until they are gone.
+	BlockOnDependents DeletionMode = iota
+	// CascadeDeleteDependents deletes the dependent IpAddress objects
+	// before freeing the address.
+	CascadeDeleteDependents
+)
+
+// ResyncMode controls how SharedController reacts when an IpAddress's
+// Status no longer matches what the IPAM backend reports.
+type ResyncMode int
+
+const (
+	// ReportOnly only emits a Drifted event and leaves the object alone.
+	ReportOnly ResyncMode = iota
+	// Repair clears the drifted Status so the object is re-assigned.
+	Repair
 )
 
 type SharedController struct {
@@ -25,6 +87,291 @@ type SharedController struct {
 	Tag          string
 	NameTemplate *template.Template
 	IpamName     string
+	// DeletionMode controls how addresses with dependents are deleted.
+	// The zero value is BlockOnDependents.
+	DeletionMode DeletionMode
+	// Recorder emits Kubernetes events for IpAddress objects.
+	Recorder record.EventRecorder
+	// ResyncPeriod is how often to check all assigned IpAddress objects
+	// for drift against the IPAM backend. Zero disables periodic resync.
+	ResyncPeriod time.Duration
+	// ResyncMode controls whether drift is repaired or only reported.
+	// The zero value is ReportOnly.
+	ResyncMode ResyncMode
+
+	lister ipamlisters.IpAddressLister
+	synced cache.InformerSynced
+	queue  workqueue.RateLimitingInterface
+}
+
+// NewSharedController builds the ipamName backend from the registry (see
+// RegisterBackend), wraps it with Prometheus instrumentation, and creates
+// a SharedController that reconciles IpAddress objects from the given
+// informer through a rate-limited workqueue. Call Run to start processing.
+func NewSharedController(
+	kube kubernetes.Interface,
+	ipamClient ipamclientset.Interface,
+	ipamName string,
+	backendConfig map[string]string,
+	tag string,
+	nameTemplate *template.Template,
+	informer ipaminformers.IpAddressInformer,
+) (*SharedController, error) {
+
+	backend, err := NewBackend(ipamName, backendConfig)
+	if err != nil {
+		return nil, fmt.Errorf("could not build ipam backend '%s': %s", ipamName, err.Error())
+	}
+
+	utilruntime.Must(ipamscheme.AddToScheme(scheme.Scheme))
+
+	eventBroadcaster := record.NewBroadcaster()
+	eventBroadcaster.StartLogging(log.Infof)
+	eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kube.CoreV1().Events("")})
+	recorder := eventBroadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: controllerAgentName})
+
+	c := &SharedController{
+		Kubernetes:   kube,
+		IpamClient:   ipamClient,
+		Ipam:         WithMetrics(ipamName, backend),
+		Tag:          tag,
+		NameTemplate: nameTemplate,
+		IpamName:     ipamName,
+		Recorder:     recorder,
+		lister:       informer.Lister(),
+		synced:       informer.Informer().HasSynced,
+		queue:        workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+	}
+
+	informer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    c.enqueue,
+		UpdateFunc: func(old, new interface{}) { c.enqueue(new) },
+		DeleteFunc: c.enqueue,
+	})
+
+	return c, nil
+}
+
+// enqueue adds the namespace/name key for obj to the workqueue. It is used
+// as an informer event handler, so repeated rapid updates for the same
+// object collapse to a single queue entry.
+func (c *SharedController) enqueue(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	c.queue.Add(key)
+}
+
+// Run starts the given number of worker goroutines and blocks until stopCh
+// is closed.
+func (c *SharedController) Run(workers int, stopCh <-chan struct{}) error {
+	defer utilruntime.HandleCrash()
+	defer c.queue.ShutDown()
+
+	log.Info("starting ipam controller")
+
+	if !cache.WaitForCacheSync(stopCh, c.synced) {
+		return fmt.Errorf("timed out waiting for caches to sync")
+	}
+
+	for i := 0; i < workers; i++ {
+		go wait.Until(c.runWorker, time.Second, stopCh)
+	}
+
+	if c.ResyncPeriod > 0 {
+		go wait.Until(c.resync, c.ResyncPeriod, stopCh)
+	}
+
+	<-stopCh
+	log.Info("stopping ipam controller")
+
+	return nil
+}
+
+func (c *SharedController) runWorker() {
+	for c.processNextWorkItem() {
+	}
+}
+
+func (c *SharedController) processNextWorkItem() bool {
+	key, shutdown := c.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer c.queue.Done(key)
+
+	err := c.syncHandler(key.(string))
+	c.handleErr(err, key)
+
+	return true
+}
+
+// handleErr requeues key with exponential backoff on error, or forgets it
+// on success so its rate-limiting history doesn't affect future attempts.
+func (c *SharedController) handleErr(err error, key interface{}) {
+	if err == nil {
+		c.queue.Forget(key)
+		return
+	}
+
+	log.Errorf("error syncing '%s': %s, requeuing", key, err.Error())
+	c.queue.AddRateLimited(key)
+}
+
+// syncHandler re-reads the object from the lister and dispatches it to the
+// create/update or delete reconcilers. Reading from the lister instead of
+// trusting the informer event payload ensures we always reconcile against
+// the most recently observed state.
+func (c *SharedController) syncHandler(key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("invalid resource key '%s': %s", key, err.Error()))
+		return nil
+	}
+
+	a, err := c.lister.IpAddresses(namespace).Get(name)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			log.Debugf("'%s' no longer exists", key)
+			return nil
+		}
+		return err
+	}
+
+	if a.DeletionTimestamp != nil {
+		err = c.IpAddressDeleted(a)
+	} else {
+		err = c.IpAddressCreatedOrUpdated(a)
+	}
+
+	reconcileTotal.WithLabelValues(c.IpamName, resultLabel(err)).Inc()
+
+	return err
+}
+
+// resync walks every IpAddress we have assigned and confirms the IPAM
+// backend still agrees with its Status, catching addresses that were
+// freed or reassigned out-of-band. Depending on ResyncMode it either
+// repairs the drift by clearing Status so the object is re-assigned, or
+// just reports it with a Warning event.
+func (c *SharedController) resync() {
+	log.Debug("starting periodic drift check")
+
+	addresses, err := c.lister.List(labels.Everything())
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("resync: could not list IpAddress objects: %s", err.Error()))
+		return
+	}
+
+	for _, a := range addresses {
+		if a.DeletionTimestamp != nil || a.Status.Provider != c.IpamName {
+			continue
+		}
+
+		expected, actual, err := c.lookupCurrent(a)
+		if err != nil {
+			c.MakeEvent(a, ReasonDrifted, fmt.Sprintf("could not look up address for '%s-%s' in IPAM: %s", a.Namespace, a.Name, err.Error()), true)
+			continue
+		}
+		if expected == "" {
+			// never assigned
+			continue
+		}
+
+		if actual == expected {
+			continue
+		}
+
+		if c.ResyncMode != Repair {
+			c.MakeEvent(a, ReasonDrifted, fmt.Sprintf("address for '%s-%s' has drifted: status says %s, IPAM reports %s", a.Namespace, a.Name, expected, actual), true)
+			continue
+		}
+
+		log.Infof("drift detected for '%s-%s': status says %s, IPAM reports %s; re-assigning", a.Namespace, a.Name, expected, actual)
+
+		a2 := a.DeepCopy()
+		a2.Status.Address = ""
+		a2.Status.Cidr = ""
+		a2.Status.Addresses = nil
+		a2.Status.Name = ""
+		a2.Status.Provider = ""
+
+		if _, err := c.IpamClient.IpamV1().IpAddresses(a.Namespace).Update(a2); err != nil {
+			c.MakeEvent(a, ReasonDrifted, fmt.Sprintf("drift detected for '%s-%s' but could not clear status for re-assignment: %s", a.Namespace, a.Name, err.Error()), true)
+			continue
+		}
+
+		c.enqueue(a2)
+	}
+}
+
+// lookupCurrent returns a human-readable "expected" value taken from
+// Status, and what the IPAM backend currently reports for it. For a
+// single address this is a direct Lookup by name. For a range/CIDR, a
+// single IP can't represent the whole allocation, so instead it confirms
+// the backend still reports exactly the same set of hosts for the tag -
+// any difference (including the tag no longer resolving at all) is
+// reported as drift. expected is "" if a was never assigned.
+func (c *SharedController) lookupCurrent(a *ipamv1.IpAddress) (expected, actual string, err error) {
+	if a.Status.Cidr != "" {
+		hosts, err := c.Ipam.Search(a.Status.Name, true)
+		if err != nil {
+			return a.Status.Cidr, "", err
+		}
+		if sortedEqual(hosts, a.Status.Addresses) {
+			return a.Status.Cidr, a.Status.Cidr, nil
+		}
+		return a.Status.Cidr, strings.Join(hosts, ","), nil
+	}
+
+	if a.Status.Address == "" {
+		return "", "", nil
+	}
+
+	ip, err := c.Ipam.Lookup(a.Status.Name)
+	return a.Status.Address, ip, err
+}
+
+// sortedEqual reports whether a and b contain the same elements,
+// ignoring order.
+func sortedEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	pa := append([]string(nil), a...)
+	pb := append([]string(nil), b...)
+	sort.Strings(pa)
+	sort.Strings(pb)
+
+	for i := range pa {
+		if pa[i] != pb[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func containsString(s []string, e string) bool {
+	for _, a := range s {
+		if a == e {
+			return true
+		}
+	}
+	return false
+}
+
+func removeString(s []string, e string) []string {
+	r := make([]string, 0, len(s))
+	for _, a := range s {
+		if a != e {
+			r = append(r, a)
+		}
+	}
+	return r
 }
 
 // Create the name for an ipadress object
@@ -52,91 +399,126 @@ func (c *SharedController) NameFor(a *ipamv1.IpAddress) string {
 	return buffer.String()
 }
 
-// Create an event for an object.
-func (c *SharedController) MakeEvent(o metav1.Object, message string, warn bool) error {
-	var t string
+// MakeEvent records a Normal or Warning event for an IpAddress via the
+// EventRecorder, which aggregates repeated identical events instead of
+// creating one API object per call.
+func (c *SharedController) MakeEvent(o runtime.Object, reason, message string, warn bool) {
+	t := corev1.EventTypeNormal
 	if warn {
-		t = "Warning"
-	} else {
-		t = "Normal"
-	}
-
-	event := &corev1.Event{
-		ObjectMeta: metav1.ObjectMeta{
-			GenerateName: o.GetName(),
-		},
-		InvolvedObject: corev1.ObjectReference{
-			Name:            o.GetName(),
-			Namespace:       o.GetNamespace(),
-			APIVersion:      "v1",
-			UID:             o.GetUID(),
-			Kind:            "IpAddress",
-			ResourceVersion: o.GetResourceVersion(),
-		},
-		Message:        message,
-		FirstTimestamp: metav1.Now(),
-		LastTimestamp:  metav1.Now(),
-		Type:           t,
-	}
-
-	_, err := c.Kubernetes.CoreV1().Events(o.GetNamespace()).Create(event)
-	return err
+		t = corev1.EventTypeWarning
+	}
+	c.Recorder.Eventf(o, t, reason, "%s", message)
 }
 
-// Create a Warning Event for the object and also return it as an error.
-func (c *SharedController) LogEventAndFail(o metav1.Object, message string) error {
+// LogEventAndFail records a Warning event with the given reason for the
+// object and also returns the message as an error.
+func (c *SharedController) LogEventAndFail(o runtime.Object, reason, message string) error {
 	log.Error(message)
-	_ = c.MakeEvent(o, message, true)
+	c.MakeEvent(o, reason, message, true)
 	return fmt.Errorf(message)
 }
 
+// wantsRange reports whether the address requests a range or CIDR block
+// instead of a single address.
+func wantsRange(a *ipamv1.IpAddress) bool {
+	return a.Spec.PrefixLength > 0 || a.Spec.Count > 0
+}
+
+func (c *SharedController) assignRange(oname string, a *ipamv1.IpAddress) (cidr string, hosts []string, err error) {
+	if a.Spec.PrefixLength > 0 {
+		return c.Ipam.AssignPrefix(oname, a.Spec.PrefixLength)
+	}
+	return c.Ipam.AssignRange(oname, a.Spec.Count)
+}
+
 func (c *SharedController) IpAddressCreatedOrUpdated(a *ipamv1.IpAddress) error {
 	log.Debugf("processing address %s-%s", a.Namespace, a.Name)
 
 	oname := c.NameFor(a)
 
-	if a.Status.Address == "" {
+	if a.Status.Address == "" && a.Status.Cidr == "" {
 
 		log.Debugf("address is unassigned")
 
-		var ip string
-		var err error
+		a2 := a.DeepCopy()
 
-		if a.Spec.Ref == "" {
-			ip, err = c.Ipam.Assign(oname)
+		if wantsRange(a) {
+
+			cidr, hosts, err := c.assignRange(oname, a)
 			if err != nil {
-				return c.LogEventAndFail(a, fmt.Sprintf("could not assign new address for '%s-%s': %s", a.Namespace, a.Name, err.Error()))
+				return c.LogEventAndFail(a, ReasonAssignFailed, fmt.Sprintf("could not assign new range for '%s-%s': %s", a.Namespace, a.Name, err.Error()))
 			}
+
+			log.Infof("assigned range %s (%d hosts) for '%s-%s'", cidr, len(hosts), a.Namespace, a.Name)
+
+			a2.Status.Cidr = cidr
+			a2.Status.Addresses = hosts
+
 		} else {
-			addresses, err := c.Ipam.Search(a.Spec.Ref, true)
-			if err != nil {
-				return c.LogEventAndFail(a, fmt.Sprintf("error searching for address matching '%s' for '%s-%s': %s", a.Spec.Ref, a.Namespace, a.Name, err.Error()))
-			}
 
-			if len(addresses) == 0 {
-				return c.LogEventAndFail(a, fmt.Sprintf("did not find address matching '%s' for '%s-%s'", a.Spec.Ref, a.Namespace, a.Name))
-			} else if len(addresses) > 1 {
-				return c.LogEventAndFail(a, fmt.Sprintf("found %d addresses matching '%s' for '%s-%s', need exactly one", len(addresses), a.Spec.Ref, a.Namespace, a.Name))
+			var ip string
+			var err error
+
+			if a.Spec.Ref == "" {
+				ip, err = c.Ipam.Assign(oname)
+				if err != nil {
+					return c.LogEventAndFail(a, ReasonAssignFailed, fmt.Sprintf("could not assign new address for '%s-%s': %s", a.Namespace, a.Name, err.Error()))
+				}
+			} else {
+				addresses, err := c.Ipam.Search(a.Spec.Ref, true)
+				if err != nil {
+					return c.LogEventAndFail(a, ReasonAssignFailed, fmt.Sprintf("error searching for address matching '%s' for '%s-%s': %s", a.Spec.Ref, a.Namespace, a.Name, err.Error()))
+				}
+
+				if len(addresses) == 0 {
+					return c.LogEventAndFail(a, ReasonRefNotFound, fmt.Sprintf("did not find address matching '%s' for '%s-%s'", a.Spec.Ref, a.Namespace, a.Name))
+				} else if len(addresses) > 1 {
+					return c.LogEventAndFail(a, ReasonRefAmbiguous, fmt.Sprintf("found %d addresses matching '%s' for '%s-%s', need exactly one", len(addresses), a.Spec.Ref, a.Namespace, a.Name))
+				}
+
+				ip = addresses[0]
 			}
 
-			ip = addresses[0]
-		}
+			log.Infof("assigned %s for '%s-%s'", ip, a.Namespace, a.Name)
 
-		log.Infof("assigned %s for '%s-%s'", ip, a.Namespace, a.Name)
+			a2.Status.Address = ip
+		}
 
-		a2 := a.DeepCopy()
-		a2.Status.Address = ip
 		a2.Status.Name = oname
 		a2.Status.Provider = c.IpamName
 
-		_, err = c.IpamClient.IpamV1().IpAddresses(a.Namespace).Update(a2)
+		if !containsString(a2.Finalizers, Finalizer) {
+			a2.Finalizers = append(a2.Finalizers, Finalizer)
+		}
+
+		_, err := c.IpamClient.IpamV1().IpAddresses(a.Namespace).Update(a2)
 
 		if err != nil {
-			return c.LogEventAndFail(a, fmt.Sprintf("assigned address %s for '%s-%s', but could not update object: %s", ip, a.Namespace, a.Name, err.Error()))
+			return c.LogEventAndFail(a, ReasonAssignFailed, fmt.Sprintf("assigned address for '%s-%s', but could not update object: %s", a.Namespace, a.Name, err.Error()))
 		}
+
+		c.MakeEvent(a2, ReasonAssigned, fmt.Sprintf("assigned address for '%s-%s'", a.Namespace, a.Name), false)
 	} else {
 
-		// TODO: check our address still exists?
+		if !containsString(a.Finalizers, Finalizer) {
+			// The object was assigned before this controller started
+			// installing finalizers (pre-upgrade, or a reconcile that
+			// predates it). Backfill it now so a delete can't slip past
+			// the API server without us freeing the allocation.
+			a2 := a.DeepCopy()
+			a2.Finalizers = append(a2.Finalizers, Finalizer)
+
+			if _, err := c.IpamClient.IpamV1().IpAddresses(a.Namespace).Update(a2); err != nil {
+				return c.LogEventAndFail(a, ReasonAssignFailed, fmt.Sprintf("could not backfill finalizer for '%s-%s': %s", a.Namespace, a.Name, err.Error()))
+			}
+
+			log.Infof("backfilled finalizer for already-assigned address '%s-%s'", a.Namespace, a.Name)
+		}
+
+		// Drift between this Status and the IPAM backend (address freed
+		// or reassigned out-of-band) is no longer checked here - the
+		// periodic resync loop (see resync/lookupCurrent) handles that
+		// across all assigned objects instead of on every reconcile.
 
 		log.Debug("nothing to do")
 	}
@@ -144,35 +526,98 @@ func (c *SharedController) IpAddressCreatedOrUpdated(a *ipamv1.IpAddress) error
 	return nil
 }
 
-func (c *SharedController) IpAddressDeleted(a *ipamv1.IpAddress) error {
+// dependents returns the other IpAddress objects in the same namespace
+// whose Spec.Ref resolved to this address. Ranges/CIDRs (Status.Cidr) are
+// never the target of a Spec.Ref today - IpAddressCreatedOrUpdated only
+// resolves refs against single addresses - so a range can't have any.
+func (c *SharedController) dependents(a *ipamv1.IpAddress) ([]ipamv1.IpAddress, error) {
+	if a.Status.Address == "" {
+		return nil, nil
+	}
 
-	// TODO: What to do with addresses that have other addresses referring to them? Finalizer? Cascading delete?
+	list, err := c.IpamClient.IpamV1().IpAddresses(a.Namespace).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
 
-	log.Debugf("processing deleted address %s-%s", a.Namespace, a.Name)
+	var dependents []ipamv1.IpAddress
 
-	if a.Status.Provider != "" && a.Status.Provider != c.IpamName {
-		log.Debugf("ignoring address, created by provider '%s'", a.Status.Provider)
-		return nil
+	for _, other := range list.Items {
+		if other.Name == a.Name {
+			continue
+		}
+		if other.Spec.Ref != "" && other.Status.Address != "" && other.Status.Address == a.Status.Address {
+			dependents = append(dependents, other)
+		}
 	}
 
-	if a.Status.Address == "" {
-		// object was never assigned
-		log.Debug("nothing to do: address was never assigned")
+	return dependents, nil
+}
+
+func (c *SharedController) IpAddressDeleted(a *ipamv1.IpAddress) error {
+
+	log.Debugf("processing deleted address %s-%s", a.Namespace, a.Name)
+
+	if !containsString(a.Finalizers, Finalizer) {
+		log.Debug("nothing to do: finalizer not present")
 		return nil
 	}
 
-	if a.Spec.Ref != "" {
-		// a reference
-		log.Debug("nothing to do: address was a reference")
+	if a.Status.Provider != "" && a.Status.Provider != c.IpamName {
+		log.Debugf("ignoring address, created by provider '%s'", a.Status.Provider)
 		return nil
 	}
 
-	err := c.Ipam.Unassign(a.Status.Address)
-	if err != nil {
-		return c.LogEventAndFail(a, fmt.Sprintf("could not unassign address %s for '%s-%s' from IPAM: %s", a.Status.Address, a.Namespace, a.Name, err.Error()))
+	if a.Spec.Ref == "" && (a.Status.Address != "" || a.Status.Cidr != "") {
+
+		dependents, err := c.dependents(a)
+		if err != nil {
+			return c.LogEventAndFail(a, ReasonUnassignFailed, fmt.Sprintf("could not check for dependent addresses of '%s-%s': %s", a.Namespace, a.Name, err.Error()))
+		}
+
+		if len(dependents) > 0 {
+
+			names := make([]string, len(dependents))
+			for i, d := range dependents {
+				names[i] = d.Namespace + "/" + d.Name
+			}
+
+			if c.DeletionMode == BlockOnDependents {
+				return c.LogEventAndFail(a, ReasonUnassignFailed, fmt.Sprintf("cannot unassign address for '%s-%s', still referenced by: %s", a.Namespace, a.Name, strings.Join(names, ", ")))
+			}
+
+			log.Infof("cascading delete: removing %d address(es) referencing '%s-%s': %s", len(dependents), a.Namespace, a.Name, strings.Join(names, ", "))
+
+			for _, d := range dependents {
+				if err := c.IpamClient.IpamV1().IpAddresses(d.Namespace).Delete(d.Name, &metav1.DeleteOptions{}); err != nil {
+					return c.LogEventAndFail(a, ReasonUnassignFailed, fmt.Sprintf("could not cascade-delete dependent address '%s/%s': %s", d.Namespace, d.Name, err.Error()))
+				}
+			}
+		}
+
+		if a.Status.Cidr != "" {
+			if err := c.Ipam.Unassign(a.Status.Cidr); err != nil {
+				return c.LogEventAndFail(a, ReasonUnassignFailed, fmt.Sprintf("could not unassign range %s for '%s-%s' from IPAM: %s", a.Status.Cidr, a.Namespace, a.Name, err.Error()))
+			}
+			log.Debugf("range %s for '%s-%s' successfully unassigned", a.Status.Cidr, a.Namespace, a.Name)
+		} else {
+			if err := c.Ipam.Unassign(a.Status.Address); err != nil {
+				return c.LogEventAndFail(a, ReasonUnassignFailed, fmt.Sprintf("could not unassign address %s for '%s-%s' from IPAM: %s", a.Status.Address, a.Namespace, a.Name, err.Error()))
+			}
+			log.Debugf("address %s for '%s-%s' successfully unassigned", a.Status.Address, a.Namespace, a.Name)
+		}
+
+		c.MakeEvent(a, ReasonUnassigned, fmt.Sprintf("unassigned address for '%s-%s'", a.Namespace, a.Name), false)
+	} else {
+		log.Debug("nothing to do: address was never assigned or was a reference")
 	}
 
-	log.Debugf("address %s for '%s-%s' successfully unassigned", a.Status.Address, a.Namespace, a.Name)
+	a2 := a.DeepCopy()
+	a2.Finalizers = removeString(a2.Finalizers, Finalizer)
+
+	if _, err := c.IpamClient.IpamV1().IpAddresses(a.Namespace).Update(a2); err != nil {
+		return c.LogEventAndFail(a, ReasonUnassignFailed, fmt.Sprintf("unassigned address for '%s-%s', but could not remove finalizer: %s", a.Namespace, a.Name, err.Error()))
+	}
 
 	return nil
 }
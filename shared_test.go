@@ -0,0 +1,314 @@
+package ipamshared
+
+import (
+	"fmt"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+
+	ipamv1 "github.com/Nexinto/k8s-ipam/pkg/apis/ipam.nexinto.com/v1"
+	ipamfake "github.com/Nexinto/k8s-ipam/pkg/client/clientset/versioned/fake"
+)
+
+// fakeIpam is a full hand-written stand-in for ipam.Ipam. It's shared by
+// the controller and metrics decorator tests so the two don't end up with
+// independent, drifting partial implementations of the interface.
+type fakeIpam struct {
+	assignIP  string
+	assignErr error
+
+	rangeCidr  string
+	rangeHosts []string
+	rangeErr   error
+
+	unassignErr   error
+	unassignedIPs []string
+
+	searchHosts []string
+	searchErr   error
+
+	lookupIP  string
+	lookupErr error
+}
+
+func (f *fakeIpam) Assign(tag string) (string, error) {
+	return f.assignIP, f.assignErr
+}
+
+func (f *fakeIpam) AssignRange(tag string, count int) (string, []string, error) {
+	return f.rangeCidr, f.rangeHosts, f.rangeErr
+}
+
+func (f *fakeIpam) AssignPrefix(tag string, prefixLength int) (string, []string, error) {
+	return f.rangeCidr, f.rangeHosts, f.rangeErr
+}
+
+func (f *fakeIpam) Unassign(ip string) error {
+	f.unassignedIPs = append(f.unassignedIPs, ip)
+	return f.unassignErr
+}
+
+func (f *fakeIpam) Search(tag string, exact bool) ([]string, error) {
+	return f.searchHosts, f.searchErr
+}
+
+func (f *fakeIpam) Lookup(name string) (string, error) {
+	return f.lookupIP, f.lookupErr
+}
+
+func address(namespace, name, ref, addr string) *ipamv1.IpAddress {
+	return &ipamv1.IpAddress{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Spec:       ipamv1.IpAddressSpec{Ref: ref},
+		Status:     ipamv1.IpAddressStatus{Address: addr},
+	}
+}
+
+func TestContainsAndRemoveString(t *testing.T) {
+	s := []string{"a", "b", "c"}
+
+	if !containsString(s, "b") {
+		t.Error("expected containsString to find 'b'")
+	}
+	if containsString(s, "z") {
+		t.Error("expected containsString not to find 'z'")
+	}
+
+	r := removeString(s, "b")
+	if containsString(r, "b") {
+		t.Error("expected removeString to remove 'b'")
+	}
+	if len(r) != 2 {
+		t.Errorf("expected 2 elements left, got %d", len(r))
+	}
+}
+
+func TestDependentsSingleAddress(t *testing.T) {
+	owner := address("default", "pool", "", "10.0.0.1")
+	dep := address("default", "web", "pool", "10.0.0.1")
+	unrelated := address("default", "other", "pool2", "10.0.0.2")
+
+	c := &SharedController{IpamClient: ipamfake.NewSimpleClientset(owner, dep, unrelated)}
+
+	deps, err := c.dependents(owner)
+	if err != nil {
+		t.Fatalf("dependents() returned error: %s", err)
+	}
+	if len(deps) != 1 || deps[0].Name != "web" {
+		t.Errorf("expected only 'web' as a dependent, got %v", deps)
+	}
+}
+
+// TestDependentsRangeHasNoFalsePositives guards against matching unrelated
+// pending objects: a CIDR/range object's Status.Address is always "", so it
+// must never be treated as a dependent of, or an owner with dependents
+// alongside, another empty-Status.Address object.
+func TestDependentsRangeHasNoFalsePositives(t *testing.T) {
+	rangeObj := address("default", "subnet", "", "")
+	rangeObj.Status.Cidr = "10.0.0.0/30"
+	pending := address("default", "pending", "pool", "")
+
+	c := &SharedController{IpamClient: ipamfake.NewSimpleClientset(rangeObj, pending)}
+
+	deps, err := c.dependents(rangeObj)
+	if err != nil {
+		t.Fatalf("dependents() returned error: %s", err)
+	}
+	if len(deps) != 0 {
+		t.Errorf("expected no dependents for a range object with empty Status.Address, got %v", deps)
+	}
+}
+
+func TestLookupCurrentRangeMatches(t *testing.T) {
+	c := &SharedController{Ipam: &fakeIpam{searchHosts: []string{"10.0.0.2", "10.0.0.1"}}}
+	a := &ipamv1.IpAddress{Status: ipamv1.IpAddressStatus{
+		Cidr:      "10.0.0.0/30",
+		Addresses: []string{"10.0.0.1", "10.0.0.2"},
+	}}
+
+	expected, actual, err := c.lookupCurrent(a)
+	if err != nil {
+		t.Fatalf("lookupCurrent() returned error: %s", err)
+	}
+	if expected != actual {
+		t.Errorf("expected no drift for a matching range, got expected=%q actual=%q", expected, actual)
+	}
+}
+
+func TestLookupCurrentRangeDrifted(t *testing.T) {
+	c := &SharedController{Ipam: &fakeIpam{searchHosts: []string{"10.0.0.9"}}}
+	a := &ipamv1.IpAddress{Status: ipamv1.IpAddressStatus{
+		Cidr:      "10.0.0.0/30",
+		Addresses: []string{"10.0.0.1", "10.0.0.2"},
+	}}
+
+	expected, actual, err := c.lookupCurrent(a)
+	if err != nil {
+		t.Fatalf("lookupCurrent() returned error: %s", err)
+	}
+	if expected == actual {
+		t.Errorf("expected drift to be detected, got expected=%q actual=%q", expected, actual)
+	}
+}
+
+func TestLookupCurrentSingleAddress(t *testing.T) {
+	c := &SharedController{Ipam: &fakeIpam{lookupErr: fmt.Errorf("not found")}}
+	a := &ipamv1.IpAddress{Status: ipamv1.IpAddressStatus{Name: "web", Address: "10.0.0.1"}}
+
+	_, _, err := c.lookupCurrent(a)
+	if err == nil {
+		t.Error("expected lookupCurrent to propagate the backend error")
+	}
+}
+
+func TestSortedEqual(t *testing.T) {
+	cases := []struct {
+		a, b []string
+		want bool
+	}{
+		{[]string{"a", "b"}, []string{"b", "a"}, true},
+		{[]string{"a", "b"}, []string{"a"}, false},
+		{[]string{}, []string{}, true},
+		{[]string{"a", "b"}, []string{"a", "c"}, false},
+	}
+
+	for _, tc := range cases {
+		if got := sortedEqual(tc.a, tc.b); got != tc.want {
+			t.Errorf("sortedEqual(%v, %v) = %v, want %v", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
+
+func TestIpAddressCreatedOrUpdatedAssignsRange(t *testing.T) {
+	a := &ipamv1.IpAddress{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "subnet"},
+		Spec:       ipamv1.IpAddressSpec{Name: "subnet", PrefixLength: 30},
+	}
+
+	c := &SharedController{
+		IpamClient: ipamfake.NewSimpleClientset(a),
+		Ipam:       &fakeIpam{rangeCidr: "10.0.0.0/30", rangeHosts: []string{"10.0.0.1", "10.0.0.2"}},
+		IpamName:   "test",
+		Recorder:   record.NewFakeRecorder(10),
+	}
+
+	if err := c.IpAddressCreatedOrUpdated(a); err != nil {
+		t.Fatalf("IpAddressCreatedOrUpdated() returned error: %s", err)
+	}
+
+	updated, err := c.IpamClient.IpamV1().IpAddresses("default").Get("subnet", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("could not fetch updated object: %s", err)
+	}
+
+	if updated.Status.Cidr != "10.0.0.0/30" {
+		t.Errorf("expected Status.Cidr to be set, got %q", updated.Status.Cidr)
+	}
+	if len(updated.Status.Addresses) != 2 {
+		t.Errorf("expected 2 host addresses recorded, got %v", updated.Status.Addresses)
+	}
+	if !containsString(updated.Finalizers, Finalizer) {
+		t.Error("expected the finalizer to be installed on a newly assigned range")
+	}
+}
+
+func TestIpAddressCreatedOrUpdatedBackfillsFinalizer(t *testing.T) {
+	a := &ipamv1.IpAddress{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web"},
+		Spec:       ipamv1.IpAddressSpec{Name: "web"},
+		Status:     ipamv1.IpAddressStatus{Address: "10.0.0.1", Name: "web", Provider: "test"},
+	}
+
+	c := &SharedController{
+		IpamClient: ipamfake.NewSimpleClientset(a),
+		Ipam:       &fakeIpam{},
+		IpamName:   "test",
+		Recorder:   record.NewFakeRecorder(10),
+	}
+
+	if err := c.IpAddressCreatedOrUpdated(a); err != nil {
+		t.Fatalf("IpAddressCreatedOrUpdated() returned error: %s", err)
+	}
+
+	updated, err := c.IpamClient.IpamV1().IpAddresses("default").Get("web", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("could not fetch updated object: %s", err)
+	}
+
+	if !containsString(updated.Finalizers, Finalizer) {
+		t.Error("expected the finalizer to be backfilled on an already-assigned object")
+	}
+}
+
+func TestIpAddressDeletedBlocksOnDependents(t *testing.T) {
+	owner := &ipamv1.IpAddress{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "owner", Finalizers: []string{Finalizer}},
+		Status:     ipamv1.IpAddressStatus{Address: "10.0.0.1"},
+	}
+	dep := &ipamv1.IpAddress{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "dep"},
+		Spec:       ipamv1.IpAddressSpec{Ref: "owner"},
+		Status:     ipamv1.IpAddressStatus{Address: "10.0.0.1"},
+	}
+
+	fake := &fakeIpam{}
+	c := &SharedController{
+		IpamClient:   ipamfake.NewSimpleClientset(owner, dep),
+		Ipam:         fake,
+		DeletionMode: BlockOnDependents,
+		Recorder:     record.NewFakeRecorder(10),
+	}
+
+	if err := c.IpAddressDeleted(owner); err == nil {
+		t.Error("expected IpAddressDeleted to block while a dependent still exists")
+	}
+
+	if len(fake.unassignedIPs) != 0 {
+		t.Errorf("expected no unassign call while blocked, got %v", fake.unassignedIPs)
+	}
+
+	if _, err := c.IpamClient.IpamV1().IpAddresses("default").Get("dep", metav1.GetOptions{}); err != nil {
+		t.Errorf("expected the dependent to survive a blocked delete, got error: %s", err)
+	}
+}
+
+func TestIpAddressDeletedCascades(t *testing.T) {
+	owner := &ipamv1.IpAddress{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "owner", Finalizers: []string{Finalizer}},
+		Status:     ipamv1.IpAddressStatus{Address: "10.0.0.1"},
+	}
+	dep := &ipamv1.IpAddress{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "dep"},
+		Spec:       ipamv1.IpAddressSpec{Ref: "owner"},
+		Status:     ipamv1.IpAddressStatus{Address: "10.0.0.1"},
+	}
+
+	fake := &fakeIpam{}
+	c := &SharedController{
+		IpamClient:   ipamfake.NewSimpleClientset(owner, dep),
+		Ipam:         fake,
+		DeletionMode: CascadeDeleteDependents,
+		Recorder:     record.NewFakeRecorder(10),
+	}
+
+	if err := c.IpAddressDeleted(owner); err != nil {
+		t.Fatalf("IpAddressDeleted() returned error: %s", err)
+	}
+
+	if len(fake.unassignedIPs) != 1 || fake.unassignedIPs[0] != "10.0.0.1" {
+		t.Errorf("expected the owner's address to be unassigned, got %v", fake.unassignedIPs)
+	}
+
+	if _, err := c.IpamClient.IpamV1().IpAddresses("default").Get("dep", metav1.GetOptions{}); err == nil {
+		t.Error("expected the dependent to be cascade-deleted")
+	}
+
+	updated, err := c.IpamClient.IpamV1().IpAddresses("default").Get("owner", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("could not fetch updated owner: %s", err)
+	}
+	if containsString(updated.Finalizers, Finalizer) {
+		t.Error("expected the finalizer to be removed once unassignment succeeds")
+	}
+}